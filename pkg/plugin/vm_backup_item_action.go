@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kvcore "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+)
+
+var vmiGroupResource = schema.GroupResource{Group: "kubevirt.io", Resource: "virtualmachineinstances"}
+
+// VMBackupItemAction backs up a VirtualMachine, pulling its running
+// VirtualMachineInstance into the same ItemBlock so that the VMI, its
+// launcher pod and its volumes are never split across Velero's parallel
+// backup workers.
+type VMBackupItemAction struct {
+	log      logrus.FieldLogger
+	kvClient kubecli.KubevirtClient
+}
+
+// NewVMBackupItemAction creates a new VMBackupItemAction.
+func NewVMBackupItemAction(log logrus.FieldLogger) *VMBackupItemAction {
+	kvClient, err := kubecli.GetKubevirtClientFromClientConfig()
+	if err != nil {
+		log.Warnf("failed to create kubevirt client: %v", err)
+	}
+
+	return &VMBackupItemAction{
+		log:      log,
+		kvClient: kvClient,
+	}
+}
+
+// AppliesTo returns the resources that this action should be invoked for.
+func (p *VMBackupItemAction) AppliesTo() (velero.ResourceSelector, error) {
+	return velero.ResourceSelector{
+		IncludedResources: []string{"virtualmachines.kubevirt.io"},
+	}, nil
+}
+
+// Name returns the name Velero uses to identify this action when matching it
+// to an ItemBlock.
+func (p *VMBackupItemAction) Name() string {
+	return "VMBackupItemAction"
+}
+
+// Execute adds the VM's running VirtualMachineInstance, if any, to the
+// backup's extra resources.
+func (p *VMBackupItemAction) Execute(item runtime.Unstructured, backup *velerov1.Backup) (runtime.Unstructured, []velero.ResourceIdentifier, error) {
+	p.log.Info("Executing VMBackupItemAction")
+
+	unstructuredVM, ok := item.(*unstructured.Unstructured)
+	if !ok {
+		return nil, nil, fmt.Errorf("item is not an unstructured.Unstructured: %T", item)
+	}
+
+	var vm kvcore.VirtualMachine
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredVM.UnstructuredContent(), &vm); err != nil {
+		return nil, nil, err
+	}
+
+	extra := []velero.ResourceIdentifier{}
+
+	if p.kvClient != nil {
+		if _, err := p.kvClient.VirtualMachineInstance(vm.Namespace).Get(context.TODO(), vm.Name, &metav1.GetOptions{}); err == nil {
+			extra = append(extra, velero.ResourceIdentifier{
+				GroupResource: vmiGroupResource,
+				Namespace:     vm.Namespace,
+				Name:          vm.Name,
+			})
+		}
+	}
+
+	return unstructuredVM, extra, nil
+}
+
+// GetAdditionalItems satisfies Velero's ItemBlockAction interface (BIAv2):
+// the VM's additional items are exactly its running VMI, which itself
+// expands (via VMIBackupItemAction) into the launcher pod and volumes.
+func (p *VMBackupItemAction) GetAdditionalItems(item runtime.Unstructured, backup *velerov1.Backup) ([]velero.ResourceIdentifier, error) {
+	_, extra, err := p.Execute(item, backup)
+	return extra, err
+}
@@ -0,0 +1,37 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestVMBackupItemActionName(t *testing.T) {
+	action := &VMBackupItemAction{log: logrus.StandardLogger()}
+	assert.Equal(t, "VMBackupItemAction", action.Name())
+}
+
+func TestVMBackupItemActionExecuteWithoutRunningVMI(t *testing.T) {
+	logrus.SetLevel(logrus.ErrorLevel)
+
+	vm := unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "kubevirt.io",
+			"kind":       "VirtualMachine",
+			"metadata": map[string]interface{}{
+				"name":      "test-vm",
+				"namespace": "test-namespace",
+			},
+		},
+	}
+
+	action := &VMBackupItemAction{log: logrus.StandardLogger()}
+
+	output, extra, err := action.Execute(&vm, nil)
+
+	assert.NoError(t, err)
+	assert.Same(t, &vm, output)
+	assert.Empty(t, extra)
+}
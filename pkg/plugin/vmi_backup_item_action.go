@@ -0,0 +1,410 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/kuberesource"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	kvcore "kubevirt.io/api/core/v1"
+	"kubevirt.io/client-go/kubecli"
+
+	snapshotclientset "github.com/kubernetes-csi/external-snapshotter/client/v6/clientset/versioned"
+
+	"kubevirt.io/kubevirt-velero-plugin/pkg/repository"
+	"kubevirt.io/kubevirt-velero-plugin/pkg/uploader"
+	"kubevirt.io/kubevirt-velero-plugin/pkg/util"
+)
+
+// uploaderTypeEnvVar selects the uploader backend addUploaderBackups uses
+// for filesystem-mode volumes. An empty/unset value disables the uploader
+// path entirely and leaves volume backup to CSI, as before.
+const uploaderTypeEnvVar = "UPLOADER_TYPE"
+
+const (
+	// annIsOwned marks a VirtualMachineInstance backup item as belonging to a
+	// VirtualMachine so the restore side can re-establish ownership.
+	annIsOwned = "cdi.kubevirt.io/velero.isOwned"
+
+	launcherLabel     = "kubevirt.io"
+	launcherLabelVal  = "virt-launcher"
+	launcherDomainAnn = "kubevirt.io/domain"
+
+	resourcePods                  = "pods"
+	resourcePersistentVolumeClaim = "persistentvolumeclaims"
+	resourceDataVolume            = "datavolumes"
+	resourceVirtualMachines       = "virtualmachines"
+)
+
+var dataVolumeGroupResource = schema.GroupResource{Group: "cdi.kubevirt.io", Resource: resourceDataVolume}
+
+// isVMExcludedByLabel reports whether the VirtualMachine owning vmi carries
+// the exclude-from-backup label. It is a package level variable so tests can
+// stub it out without a live cluster.
+var isVMExcludedByLabel = func(vmi *kvcore.VirtualMachineInstance) (bool, error) {
+	if len(vmi.OwnerReferences) == 0 {
+		return false, nil
+	}
+
+	client, err := kubecli.GetKubevirtClientFromClientConfig()
+	if err != nil {
+		return false, err
+	}
+
+	vm, err := client.VirtualMachine(vmi.Namespace).Get(context.TODO(), vmi.OwnerReferences[0].Name, &metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	return vm.Labels[util.ExcludeFromBackupLabel] == "true", nil
+}
+
+// VMIBackupItemAction backs up a VirtualMachineInstance, making sure that its
+// launcher pod and the volumes it depends on travel with it.
+type VMIBackupItemAction struct {
+	log            logrus.FieldLogger
+	client         kubernetes.Interface
+	kvClient       kubecli.KubevirtClient
+	snapshotClient snapshotclientset.Interface
+	dynamicClient  dynamic.Interface
+	uploaderType   uploader.Type
+	repoEnsurer    *repository.RepositoryEnsurer
+}
+
+// NewVMIBackupItemAction creates a new VMIBackupItemAction.
+func NewVMIBackupItemAction(log logrus.FieldLogger, client kubernetes.Interface) *VMIBackupItemAction {
+	kvClient, err := kubecli.GetKubevirtClientFromClientConfig()
+	if err != nil {
+		log.Warnf("failed to create kubevirt client: %v", err)
+	}
+
+	cfg, cfgErr := rest.InClusterConfig()
+	if cfgErr != nil {
+		log.Warnf("failed to build in-cluster client config: %v", cfgErr)
+	}
+
+	var snapshotClient snapshotclientset.Interface
+	if cfgErr == nil {
+		if snapshotClient, err = snapshotclientset.NewForConfig(cfg); err != nil {
+			log.Warnf("failed to create snapshot client: %v", err)
+		}
+	}
+
+	var dynamicClient dynamic.Interface
+	if cfgErr == nil {
+		if dynamicClient, err = dynamic.NewForConfig(cfg); err != nil {
+			log.Warnf("failed to create dynamic client: %v", err)
+		}
+	}
+
+	return &VMIBackupItemAction{
+		log:            log,
+		client:         client,
+		kvClient:       kvClient,
+		snapshotClient: snapshotClient,
+		dynamicClient:  dynamicClient,
+		uploaderType:   uploader.Type(os.Getenv(uploaderTypeEnvVar)),
+		repoEnsurer:    repository.NewForClient(client),
+	}
+}
+
+// AppliesTo returns the resources that this action should be invoked for.
+func (p *VMIBackupItemAction) AppliesTo() (velero.ResourceSelector, error) {
+	return velero.ResourceSelector{
+		IncludedResources: []string{"virtualmachineinstances.kubevirt.io"},
+	}, nil
+}
+
+// Execute backs up a VMI along with its launcher pod and its PVC/DataVolume
+// volumes, failing the backup when those dependencies are not captured too.
+func (p *VMIBackupItemAction) Execute(item runtime.Unstructured, backup *velerov1.Backup) (runtime.Unstructured, []velero.ResourceIdentifier, error) {
+	p.log.Info("Executing VMIBackupItemAction")
+
+	unstructuredVMI, vmi, err := decodeVMI(item)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	extra, err := p.computeExtras(unstructuredVMI, vmi, backup, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return unstructuredVMI, extra, nil
+}
+
+// computeExtras works out every additional item Execute needs to back up
+// alongside the VMI, optionally performing the side effects (freezing the
+// guest, creating VolumeSnapshots/PodVolumeBackups) that producing some of
+// those items requires. persist is false when called from
+// GetAdditionalItems, which must stay a pure, repeatable query: Velero calls
+// it separately from, and before, Execute during ItemBlock dependency
+// resolution, and the deterministic names used by the online-backup and
+// uploader-backup paths would otherwise collide with the objects Execute
+// creates later, failing with AlreadyExists.
+func (p *VMIBackupItemAction) computeExtras(unstructuredVMI *unstructured.Unstructured, vmi *kvcore.VirtualMachineInstance, backup *velerov1.Backup, persist bool) ([]velero.ResourceIdentifier, error) {
+	extra := []velero.ResourceIdentifier{}
+
+	podExcludedByLabel, err := p.isLauncherPodExcluded(vmi)
+	if err != nil {
+		return nil, err
+	}
+	podExcluded := !resourceIncluded(resourcePods, backup) || podExcludedByLabel
+	pvcIncluded := resourceIncluded(resourcePersistentVolumeClaim, backup)
+
+	claimedByOtherPath := map[string]bool{}
+
+	if !isVMIPaused(vmi) && podExcluded && pvcIncluded {
+		if backup.Annotations[annOnlineBackup] != "true" {
+			return nil, errors.New("VM is running but launcher pod is not included in the backup")
+		}
+
+		var onlineExtra []velero.ResourceIdentifier
+		var onlineErr error
+		if persist {
+			onlineExtra, onlineErr = p.executeOnlineBackup(vmi, backup)
+		} else {
+			onlineExtra, onlineErr = p.onlineBackupExtras(vmi, backup)
+		}
+		if onlineErr != nil {
+			p.log.Warnf("online backup of %s/%s failed, falling back to strict validation: %v", vmi.Namespace, vmi.Name, onlineErr)
+			return nil, errors.New("VM is running but launcher pod is not included in the backup")
+		}
+
+		extra = append(extra, onlineExtra...)
+
+		claimedByOtherPath = onlineBackupVolumeNames(vmi)
+	}
+
+	if owned := len(vmi.OwnerReferences) > 0; owned {
+		vmExcludedByLabel, err := isVMExcludedByLabel(vmi)
+		if err != nil {
+			return nil, err
+		}
+
+		if !resourceIncluded(resourceVirtualMachines, backup) || vmExcludedByLabel {
+			return nil, errors.New("VMI owned by a VM and the VM is not included in the backup")
+		}
+
+		setAnnotation(unstructuredVMI, annIsOwned, "true")
+	}
+
+	volumes, err := p.selectVolumes(vmi)
+	if err != nil {
+		return nil, err
+	}
+	volumes = excludeVolumesByName(volumes, claimedByOtherPath)
+
+	fsVolumes, err := p.selectFilesystemVolumes(vmi.Namespace, volumes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Run the uploader step before addVolumes, and only exclude the volumes
+	// it actually claimed: a fsVolumes candidate it bails out on (e.g. its
+	// repository could not be provisioned) must still go through addVolumes
+	// so CSI backs it up instead, rather than silently dropping it.
+	var uploaderExtra []velero.ResourceIdentifier
+	var uploaderClaimed map[string]bool
+	if persist {
+		uploaderExtra, uploaderClaimed, err = p.addUploaderBackups(unstructuredVMI, vmi, fsVolumes, nil)
+	} else {
+		uploaderExtra, uploaderClaimed, err = p.uploaderBackupExtras(vmi, fsVolumes, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	extra, err = p.addVolumes(vmi.Namespace, excludeVolumesByName(volumes, uploaderClaimed), backup, extra)
+	if err != nil {
+		return nil, err
+	}
+	extra = append(extra, uploaderExtra...)
+
+	extra, err = p.addLauncherPod(vmi, extra)
+	if err != nil {
+		return nil, err
+	}
+
+	return extra, nil
+}
+
+// decodeVMI type-asserts item to *unstructured.Unstructured and decodes it
+// into a VirtualMachineInstance.
+func decodeVMI(item runtime.Unstructured) (*unstructured.Unstructured, *kvcore.VirtualMachineInstance, error) {
+	unstructuredVMI, ok := item.(*unstructured.Unstructured)
+	if !ok {
+		return nil, nil, fmt.Errorf("item is not an unstructured.Unstructured: %T", item)
+	}
+
+	var vmi kvcore.VirtualMachineInstance
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredVMI.UnstructuredContent(), &vmi); err != nil {
+		return nil, nil, err
+	}
+
+	return unstructuredVMI, &vmi, nil
+}
+
+// addVolumes walks volumes and makes sure every PersistentVolumeClaim and
+// DataVolume they reference is included in the backup, appending each to
+// extra so Velero backs it up alongside the VMI.
+func (p *VMIBackupItemAction) addVolumes(namespace string, volumes []kvcore.Volume, backup *velerov1.Backup, extra []velero.ResourceIdentifier) ([]velero.ResourceIdentifier, error) {
+	for _, volume := range volumes {
+		switch {
+		case volume.PersistentVolumeClaim != nil:
+			excluded, err := util.IsPVCExcludedByLabel(namespace, volume.PersistentVolumeClaim.ClaimName)
+			if err != nil {
+				return nil, err
+			}
+			if excluded {
+				continue
+			}
+			if !resourceIncluded(resourcePersistentVolumeClaim, backup) {
+				return nil, errors.New("VM has DataVolume or PVC volumes and DataVolumes/PVCs is not included in the backup")
+			}
+			extra = append(extra, velero.ResourceIdentifier{
+				GroupResource: kuberesource.PersistentVolumeClaims,
+				Namespace:     namespace,
+				Name:          volume.PersistentVolumeClaim.ClaimName,
+			})
+		case volume.DataVolume != nil:
+			if !resourceIncluded(resourceDataVolume, backup) {
+				return nil, errors.New("VM has DataVolume or PVC volumes and DataVolumes/PVCs is not included in the backup")
+			}
+			extra = append(extra, velero.ResourceIdentifier{
+				GroupResource: dataVolumeGroupResource,
+				Namespace:     namespace,
+				Name:          volume.DataVolume.Name,
+			})
+		}
+	}
+
+	return extra, nil
+}
+
+// addLauncherPod appends the VMI's virt-launcher pod to extra, if one exists.
+func (p *VMIBackupItemAction) addLauncherPod(vmi *kvcore.VirtualMachineInstance, extra []velero.ResourceIdentifier) ([]velero.ResourceIdentifier, error) {
+	pods, err := p.client.CoreV1().Pods(vmi.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", launcherLabel, launcherLabelVal),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Annotations[launcherDomainAnn] != vmi.Name {
+			continue
+		}
+
+		extra = append(extra, velero.ResourceIdentifier{
+			GroupResource: kuberesource.Pods,
+			Namespace:     pod.Namespace,
+			Name:          pod.Name,
+		})
+	}
+
+	return extra, nil
+}
+
+// isLauncherPodExcluded reports whether the VMI's launcher pod carries the
+// exclude-from-backup label.
+func (p *VMIBackupItemAction) isLauncherPodExcluded(vmi *kvcore.VirtualMachineInstance) (bool, error) {
+	pods, err := p.client.CoreV1().Pods(vmi.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", launcherLabel, launcherLabelVal),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Annotations[launcherDomainAnn] != vmi.Name {
+			continue
+		}
+		if pod.Labels[util.ExcludeFromBackupLabel] == "true" {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Name returns the name Velero uses to identify this action when matching it
+// to an ItemBlock.
+func (p *VMIBackupItemAction) Name() string {
+	return "VMIBackupItemAction"
+}
+
+// GetAdditionalItems satisfies Velero's ItemBlockAction interface (BIAv2):
+// it reports the same additional items Execute would produce, so Velero's
+// parallel backup workers process the VMI, its launcher pod, its volumes and
+// its owning VM as a single ItemBlock instead of racing independent workers
+// over resources they share. Velero calls this separately from, and before,
+// the real Execute call, so it must stay a pure, repeatable query: unlike
+// Execute, it never freezes the guest or creates VolumeSnapshots/
+// PodVolumeBackups.
+func (p *VMIBackupItemAction) GetAdditionalItems(item runtime.Unstructured, backup *velerov1.Backup) ([]velero.ResourceIdentifier, error) {
+	_, vmi, err := decodeVMI(item)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.computeExtras(&unstructured.Unstructured{}, vmi, backup, false)
+}
+
+// setAnnotation sets key to value on obj's annotations, preserving whatever
+// annotations are already there.
+func setAnnotation(obj *unstructured.Unstructured, key, value string) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[key] = value
+	obj.SetAnnotations(annotations)
+}
+
+// isVMIPaused reports whether the VMI has a Paused=True condition, in which
+// case it has no running launcher pod to protect.
+func isVMIPaused(vmi *kvcore.VirtualMachineInstance) bool {
+	for _, cond := range vmi.Status.Conditions {
+		if cond.Type == kvcore.VirtualMachineInstancePaused && cond.Status == "True" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resourceIncluded reports whether resource is included in the backup given
+// its IncludedResources/ExcludedResources lists. An empty ExcludedResources
+// combined with an empty IncludedResources means everything is included.
+func resourceIncluded(resource string, backup *velerov1.Backup) bool {
+	for _, r := range backup.Spec.ExcludedResources {
+		if strings.EqualFold(r, resource) {
+			return false
+		}
+	}
+
+	if len(backup.Spec.IncludedResources) == 0 {
+		return true
+	}
+
+	for _, r := range backup.Spec.IncludedResources {
+		if strings.EqualFold(r, resource) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -14,9 +14,11 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	k8sfake "k8s.io/client-go/kubernetes/fake"
 	kvcore "kubevirt.io/api/core/v1"
 	"kubevirt.io/client-go/kubecli"
+	"kubevirt.io/kubevirt-velero-plugin/pkg/uploader"
 	"kubevirt.io/kubevirt-velero-plugin/pkg/util"
 )
 
@@ -616,3 +618,174 @@ func TestAddLauncherPod(t *testing.T) {
 		})
 	}
 }
+
+func TestVMIBackupItemActionGetAdditionalItemsBlock(t *testing.T) {
+	logrus.SetLevel(logrus.ErrorLevel)
+	isVMExcludedByLabel = func(vmi *kvcore.VirtualMachineInstance) (bool, error) { return false, nil }
+	util.IsPVCExcludedByLabel = func(namespace, pvcName string) (bool, error) { return false, nil }
+
+	newVMI := func(name, pvcName, dvName string) unstructured.Unstructured {
+		return unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": "kubevirt.io",
+				"kind":       "VirtualMachineInterface",
+				"metadata": map[string]interface{}{
+					"name":      name,
+					"namespace": "test-namespace",
+				},
+				"spec": map[string]interface{}{
+					"volumes": []interface{}{
+						map[string]interface{}{
+							"persistentVolumeClaim": map[string]interface{}{
+								"claimName": pvcName,
+							},
+						},
+						map[string]interface{}{
+							"dataVolume": map[string]interface{}{
+								"name": dvName,
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	firstVMI := newVMI("test-vmi-1", "pvc-1", "dv-1")
+	secondVMI := newVMI("test-vmi-2", "pvc-2", "dv-2")
+
+	client := k8sfake.NewSimpleClientset()
+	action := NewVMIBackupItemAction(logrus.StandardLogger(), client)
+
+	firstExtra, err := action.GetAdditionalItems(&firstVMI, &velerov1.Backup{})
+	assert.NoError(t, err)
+	secondExtra, err := action.GetAdditionalItems(&secondVMI, &velerov1.Backup{})
+	assert.NoError(t, err)
+
+	pvcResource := velero.ResourceIdentifier{
+		GroupResource: kuberesource.PersistentVolumeClaims,
+		Namespace:     "test-namespace",
+		Name:          "pvc-1",
+	}
+	dvResource := velero.ResourceIdentifier{
+		GroupResource: schema.GroupResource{Group: "cdi.kubevirt.io", Resource: "datavolumes"},
+		Namespace:     "test-namespace",
+		Name:          "dv-1",
+	}
+	assert.Contains(t, firstExtra, pvcResource)
+	assert.Contains(t, firstExtra, dvResource)
+
+	for _, id := range firstExtra {
+		assert.NotContains(t, secondExtra, id, "two VMIs' additional items must not share a PVC or DataVolume")
+	}
+}
+
+func TestGetAdditionalItemsExcludesOnlineBackupVolumeFromAddVolumes(t *testing.T) {
+	logrus.SetLevel(logrus.ErrorLevel)
+	util.IsPVCExcludedByLabel = func(namespace, pvcName string) (bool, error) { return false, nil }
+
+	item := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "kubevirt.io",
+		"kind":       "VirtualMachineInterface",
+		"metadata": map[string]interface{}{
+			"name":      "test-vmi",
+			"namespace": "test-namespace",
+		},
+		"spec": map[string]interface{}{
+			"volumes": []interface{}{
+				map[string]interface{}{
+					"name": "disk",
+					"persistentVolumeClaim": map[string]interface{}{
+						"claimName": "test-pvc",
+					},
+				},
+			},
+		},
+	}}
+
+	action := &VMIBackupItemAction{log: logrus.StandardLogger(), client: k8sfake.NewSimpleClientset()}
+
+	backup := &velerov1.Backup{
+		ObjectMeta: metav1.ObjectMeta{
+			UID:         types.UID("backup-uid"),
+			Annotations: map[string]string{annOnlineBackup: "true"},
+		},
+		Spec: velerov1.BackupSpec{ExcludedResources: []string{resourcePods}},
+	}
+
+	extra, err := action.GetAdditionalItems(item, backup)
+	assert.NoError(t, err)
+
+	pvcResource := velero.ResourceIdentifier{
+		GroupResource: kuberesource.PersistentVolumeClaims,
+		Namespace:     "test-namespace",
+		Name:          "test-pvc",
+	}
+	snapshotResource := velero.ResourceIdentifier{
+		GroupResource: volumeSnapshotGroupResource,
+		Namespace:     "test-namespace",
+		Name:          volumeSnapshotName("test-vmi", "disk", backup),
+	}
+	assert.Contains(t, extra, snapshotResource)
+	assert.NotContains(t, extra, pvcResource, "a volume captured by online backup must not also be backed up via its PVC")
+}
+
+func TestGetAdditionalItemsExcludesUploaderVolumeFromAddVolumes(t *testing.T) {
+	logrus.SetLevel(logrus.ErrorLevel)
+	util.IsPVCExcludedByLabel = func(namespace, pvcName string) (bool, error) { return false, nil }
+
+	item := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "kubevirt.io",
+		"kind":       "VirtualMachineInterface",
+		"metadata": map[string]interface{}{
+			"name":      "test-vmi",
+			"namespace": "test-namespace",
+		},
+		"spec": map[string]interface{}{
+			"volumes": []interface{}{
+				map[string]interface{}{
+					"name": "disk",
+					"persistentVolumeClaim": map[string]interface{}{
+						"claimName": "fs-pvc",
+					},
+				},
+			},
+		},
+	}}
+
+	fsPVC := v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "fs-pvc"},
+	}
+	launcherPod := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "test-namespace",
+			Name:      "test-vmi-launcher-pod",
+			Labels:    map[string]string{"kubevirt.io": "virt-launcher"},
+			Annotations: map[string]string{
+				"kubevirt.io/domain": "test-vmi",
+			},
+		},
+	}
+
+	action := &VMIBackupItemAction{
+		log:          logrus.StandardLogger(),
+		client:       k8sfake.NewSimpleClientset(&fsPVC, &launcherPod),
+		uploaderType: uploader.Kopia,
+	}
+
+	extra, err := action.GetAdditionalItems(item, &velerov1.Backup{})
+	assert.NoError(t, err)
+
+	pvcResource := velero.ResourceIdentifier{
+		GroupResource: kuberesource.PersistentVolumeClaims,
+		Namespace:     "test-namespace",
+		Name:          "fs-pvc",
+	}
+	podVolumeBackupResource := velero.ResourceIdentifier{
+		GroupResource: podVolumeBackupGR,
+		Namespace:     "test-namespace",
+		Name:          podVolumeBackupName("test-vmi-launcher-pod", "disk"),
+	}
+	assert.Contains(t, extra, podVolumeBackupResource)
+	assert.NotContains(t, extra, pvcResource, "a volume captured by the uploader must not also be backed up via its PVC")
+}
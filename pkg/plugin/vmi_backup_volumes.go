@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+
+	kvcore "kubevirt.io/api/core/v1"
+)
+
+const (
+	// annBackupVolumes opts individual VMI disks into the backup by name,
+	// mirroring Velero's pod-volume opt-in annotation.
+	annBackupVolumes = "backup.velero.io/backup-volumes"
+
+	// annBackupVolumesExcludes opts individual VMI disks out of the backup by
+	// name. Mutually exclusive with annBackupVolumes.
+	annBackupVolumesExcludes = "backup.velero.io/backup-volumes-excludes"
+)
+
+// selectVolumes returns the subset of vmi's volumes that should be backed up,
+// honoring the annBackupVolumes/annBackupVolumesExcludes annotations. With
+// neither annotation set, all volumes are returned. Unknown volume names are
+// logged and skipped rather than failing the backup.
+func (p *VMIBackupItemAction) selectVolumes(vmi *kvcore.VirtualMachineInstance) ([]kvcore.Volume, error) {
+	include, hasInclude := vmi.Annotations[annBackupVolumes]
+	exclude, hasExclude := vmi.Annotations[annBackupVolumesExcludes]
+
+	if hasInclude && hasExclude {
+		return nil, fmt.Errorf("only one of %s and %s may be set", annBackupVolumes, annBackupVolumesExcludes)
+	}
+
+	if !hasInclude && !hasExclude {
+		return vmi.Spec.Volumes, nil
+	}
+
+	known := make(map[string]bool, len(vmi.Spec.Volumes))
+	for _, volume := range vmi.Spec.Volumes {
+		known[volume.Name] = true
+	}
+
+	requested, list := annBackupVolumes, include
+	if hasExclude {
+		requested, list = annBackupVolumesExcludes, exclude
+	}
+
+	names := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !known[name] {
+			p.log.Warnf("volume %q named in annotation %s of VMI %s/%s does not exist, skipping", name, requested, vmi.Namespace, vmi.Name)
+			continue
+		}
+		names[name] = true
+	}
+
+	selected := make([]kvcore.Volume, 0, len(vmi.Spec.Volumes))
+	for _, volume := range vmi.Spec.Volumes {
+		if names[volume.Name] == hasInclude {
+			selected = append(selected, volume)
+		}
+	}
+
+	return selected, nil
+}
+
+// excludeVolumesByName returns the subset of volumes whose name is not in
+// excluded.
+func excludeVolumesByName(volumes []kvcore.Volume, excluded map[string]bool) []kvcore.Volume {
+	remaining := make([]kvcore.Volume, 0, len(volumes))
+	for _, volume := range volumes {
+		if excluded[volume.Name] {
+			continue
+		}
+		remaining = append(remaining, volume)
+	}
+
+	return remaining
+}
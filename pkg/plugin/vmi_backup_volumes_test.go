@@ -0,0 +1,89 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kvcore "kubevirt.io/api/core/v1"
+)
+
+func TestSelectVolumes(t *testing.T) {
+	pvcVolume := kvcore.Volume{
+		Name: "disk0",
+		VolumeSource: kvcore.VolumeSource{
+			PersistentVolumeClaim: &kvcore.PersistentVolumeClaimVolumeSource{},
+		},
+	}
+	dvVolume := kvcore.Volume{
+		Name: "disk1",
+		VolumeSource: kvcore.VolumeSource{
+			DataVolume: &kvcore.DataVolumeSource{Name: "dv1"},
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		expectError bool
+		expected    []kvcore.Volume
+	}{
+		{"no annotations returns all volumes",
+			nil,
+			false,
+			[]kvcore.Volume{pvcVolume, dvVolume},
+		},
+		{"both annotations set is an error",
+			map[string]string{
+				annBackupVolumes:         "disk0",
+				annBackupVolumesExcludes: "disk1",
+			},
+			true,
+			nil,
+		},
+		{"include annotation selects only named volume",
+			map[string]string{annBackupVolumes: "disk0"},
+			false,
+			[]kvcore.Volume{pvcVolume},
+		},
+		{"exclude annotation drops named volume",
+			map[string]string{annBackupVolumesExcludes: "disk1"},
+			false,
+			[]kvcore.Volume{pvcVolume},
+		},
+		{"unknown volume name in include annotation is skipped",
+			map[string]string{annBackupVolumes: "disk0,unknown"},
+			false,
+			[]kvcore.Volume{pvcVolume},
+		},
+	}
+
+	logrus.SetLevel(logrus.ErrorLevel)
+	action := &VMIBackupItemAction{log: logrus.StandardLogger()}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			vmi := &kvcore.VirtualMachineInstance{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "test-namespace",
+					Name:        "test-vmi",
+					Annotations: tc.annotations,
+				},
+				Spec: kvcore.VirtualMachineInstanceSpec{
+					Volumes: []kvcore.Volume{pvcVolume, dvVolume},
+				},
+			}
+
+			selected, err := action.selectVolumes(vmi)
+
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, selected)
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kvcore "kubevirt.io/api/core/v1"
+
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v6/apis/volumesnapshot/v1"
+)
+
+const (
+	// annOnlineBackup, set by the user on the Backup, asks the plugin to take
+	// a live VMI whose launcher pod is not part of the backup while its PVCs
+	// are, and capture it anyway via a guest-agent freeze and a CSI
+	// VolumeSnapshot per disk, instead of failing with the usual
+	// strict-validation error.
+	annOnlineBackup = "kubevirt.io/online-backup"
+
+	volumeSnapshotReadyTimeout = 10 * time.Minute
+	volumeSnapshotPollInterval = 5 * time.Second
+)
+
+var volumeSnapshotGroupResource = schema.GroupResource{Group: "snapshot.storage.k8s.io", Resource: "volumesnapshots"}
+
+// volumeSnapshotName derives the deterministic name of the VolumeSnapshot
+// taken for volumeName during the online backup identified by backup's UID.
+func volumeSnapshotName(vmiName, volumeName string, backup *velerov1.Backup) string {
+	return fmt.Sprintf("%s-%s-%s", vmiName, volumeName, backup.UID)
+}
+
+// onlineBackupVolumeNames returns the names of vmi's PVC-backed volumes,
+// which is exactly the set executeOnlineBackup/onlineBackupExtras capture a
+// VolumeSnapshot for, so callers can exclude them from being backed up again
+// some other way.
+func onlineBackupVolumeNames(vmi *kvcore.VirtualMachineInstance) map[string]bool {
+	names := map[string]bool{}
+	for _, volume := range vmi.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil {
+			names[volume.Name] = true
+		}
+	}
+
+	return names
+}
+
+// executeOnlineBackup freezes the running VMI's filesystem via the
+// qemu-guest-agent, takes a CSI VolumeSnapshot of each PVC-backed disk, waits
+// for the snapshots to become ready and thaws the guest again. It is the
+// fallback path used by Execute when the launcher pod is not part of the
+// backup while its PVCs are, and the user opted in via annOnlineBackup.
+func (p *VMIBackupItemAction) executeOnlineBackup(vmi *kvcore.VirtualMachineInstance, backup *velerov1.Backup) ([]velero.ResourceIdentifier, error) {
+	if p.kvClient == nil {
+		return nil, fmt.Errorf("no kubevirt client available to freeze %s/%s", vmi.Namespace, vmi.Name)
+	}
+
+	if err := p.kvClient.VirtualMachineInstance(vmi.Namespace).Freeze(context.TODO(), vmi.Name, 0); err != nil {
+		return nil, fmt.Errorf("freezing %s/%s: %w", vmi.Namespace, vmi.Name, err)
+	}
+
+	extra, err := p.snapshotVolumes(vmi, backup)
+
+	if thawErr := p.kvClient.VirtualMachineInstance(vmi.Namespace).Unfreeze(context.TODO(), vmi.Name); thawErr != nil {
+		p.log.Warnf("failed to thaw %s/%s after online backup: %v", vmi.Namespace, vmi.Name, thawErr)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return extra, nil
+}
+
+// onlineBackupExtras reports the VolumeSnapshots executeOnlineBackup would
+// create for vmi's PVC volumes, without freezing the guest or creating
+// anything. It is used by GetAdditionalItems in place of executeOnlineBackup,
+// since that call must stay side-effect free.
+func (p *VMIBackupItemAction) onlineBackupExtras(vmi *kvcore.VirtualMachineInstance, backup *velerov1.Backup) ([]velero.ResourceIdentifier, error) {
+	extra := []velero.ResourceIdentifier{}
+
+	for _, volume := range vmi.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+
+		extra = append(extra, velero.ResourceIdentifier{
+			GroupResource: volumeSnapshotGroupResource,
+			Namespace:     vmi.Namespace,
+			Name:          volumeSnapshotName(vmi.Name, volume.Name, backup),
+		})
+	}
+
+	return extra, nil
+}
+
+func (p *VMIBackupItemAction) snapshotVolumes(vmi *kvcore.VirtualMachineInstance, backup *velerov1.Backup) ([]velero.ResourceIdentifier, error) {
+	if p.snapshotClient == nil {
+		return nil, fmt.Errorf("no snapshot client available to back up %s/%s online", vmi.Namespace, vmi.Name)
+	}
+
+	extra := []velero.ResourceIdentifier{}
+
+	for _, volume := range vmi.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+
+		vsName := volumeSnapshotName(vmi.Name, volume.Name, backup)
+		claimName := volume.PersistentVolumeClaim.ClaimName
+
+		vs := &snapshotv1.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      vsName,
+				Namespace: vmi.Namespace,
+			},
+			Spec: snapshotv1.VolumeSnapshotSpec{
+				Source: snapshotv1.VolumeSnapshotSource{
+					PersistentVolumeClaimName: &claimName,
+				},
+			},
+		}
+
+		if _, err := p.snapshotClient.SnapshotV1().VolumeSnapshots(vmi.Namespace).Create(context.TODO(), vs, metav1.CreateOptions{}); err != nil {
+			return nil, fmt.Errorf("creating VolumeSnapshot %s/%s: %w", vmi.Namespace, vsName, err)
+		}
+
+		if err := p.waitForVolumeSnapshotReady(vmi.Namespace, vsName); err != nil {
+			return nil, err
+		}
+
+		extra = append(extra, velero.ResourceIdentifier{
+			GroupResource: volumeSnapshotGroupResource,
+			Namespace:     vmi.Namespace,
+			Name:          vsName,
+		})
+	}
+
+	return extra, nil
+}
+
+func (p *VMIBackupItemAction) waitForVolumeSnapshotReady(namespace, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), volumeSnapshotReadyTimeout)
+	defer cancel()
+
+	return wait.PollUntilContextTimeout(ctx, volumeSnapshotPollInterval, volumeSnapshotReadyTimeout, true, func(ctx context.Context) (bool, error) {
+		vs, err := p.snapshotClient.SnapshotV1().VolumeSnapshots(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		return vs.Status != nil && vs.Status.ReadyToUse != nil && *vs.Status.ReadyToUse, nil
+	})
+}
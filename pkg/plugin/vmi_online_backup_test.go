@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"testing"
+
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v6/apis/volumesnapshot/v1"
+	snapshotfake "github.com/kubernetes-csi/external-snapshotter/client/v6/clientset/versioned/fake"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clienttesting "k8s.io/client-go/testing"
+	kvcore "kubevirt.io/api/core/v1"
+)
+
+func TestExecuteOnlineBackupWithoutKubevirtClient(t *testing.T) {
+	logrus.SetLevel(logrus.ErrorLevel)
+
+	action := &VMIBackupItemAction{log: logrus.StandardLogger()}
+	vmi := &kvcore.VirtualMachineInstance{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "test-vmi"},
+	}
+
+	_, err := action.executeOnlineBackup(vmi, &velerov1.Backup{})
+	assert.Error(t, err)
+}
+
+func TestSnapshotVolumes(t *testing.T) {
+	logrus.SetLevel(logrus.ErrorLevel)
+
+	vmi := &kvcore.VirtualMachineInstance{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "test-vmi"},
+		Spec: kvcore.VirtualMachineInstanceSpec{
+			Volumes: []kvcore.Volume{
+				{
+					Name: "rootdisk",
+					VolumeSource: kvcore.VolumeSource{
+						PersistentVolumeClaim: &kvcore.PersistentVolumeClaimVolumeSource{
+							PersistentVolumeClaimVolumeSource: corev1.PersistentVolumeClaimVolumeSource{ClaimName: "test-pvc"},
+						},
+					},
+				},
+			},
+		},
+	}
+	backup := &velerov1.Backup{ObjectMeta: metav1.ObjectMeta{UID: types.UID("backup-uid")}}
+
+	client := snapshotfake.NewSimpleClientset()
+	client.PrependReactor("get", "volumesnapshots", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		ready := true
+		return true, &snapshotv1.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-vmi-rootdisk-backup-uid", Namespace: "test-namespace"},
+			Status:     &snapshotv1.VolumeSnapshotStatus{ReadyToUse: &ready},
+		}, nil
+	})
+
+	action := &VMIBackupItemAction{
+		log:            logrus.StandardLogger(),
+		snapshotClient: client,
+	}
+
+	extra, err := action.snapshotVolumes(vmi, backup)
+	assert.NoError(t, err)
+	assert.Len(t, extra, 1)
+	assert.Equal(t, "test-vmi-rootdisk-backup-uid", extra[0].Name)
+	assert.Equal(t, volumeSnapshotGroupResource, extra[0].GroupResource)
+}
@@ -0,0 +1,316 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	kvcore "kubevirt.io/api/core/v1"
+
+	snapshotclientset "github.com/kubernetes-csi/external-snapshotter/client/v6/clientset/versioned"
+)
+
+// annOriginalVMIUID records the UID the VMI had in the source cluster, for
+// traceability back to the backup it came from.
+const annOriginalVMIUID = "restore.velero.io/original-vmi-uid"
+
+const (
+	// veleroNamespaceEnvVar names the env var the plugin process inherits
+	// from the Velero server/node-agent pod it runs alongside.
+	veleroNamespaceEnvVar  = "VELERO_NAMESPACE"
+	defaultVeleroNamespace = "velero"
+
+	// dataUploadResultBackupLabel/dataUploadResultUsageLabel/
+	// dataUploadResultUsageValue select the configmaps Velero's node-agent
+	// leaves behind per backup once a DataUpload-backed volume is restored.
+	dataUploadResultBackupLabel = "velero.io/backup-name"
+	dataUploadResultUsageLabel  = "velero.io/resource-usage"
+	dataUploadResultUsageValue  = "DataUploadResult"
+
+	dataUploadResultSourceNameKey      = "sourceName"
+	dataUploadResultSourceNamespaceKey = "sourceNamespace"
+	dataUploadResultRestoredPVCKey     = "restoredPVCName"
+)
+
+// VolumeSnapshotInfo is the minimal view of one of the backup's captured CSI
+// VolumeSnapshots needed to match it to the PVC volume it backed up and find
+// the PVC that was provisioned from it during restore.
+type VolumeSnapshotInfo struct {
+	SourcePVCName      string
+	SourcePVCNamespace string
+	RestoredPVCName    string
+}
+
+// DataUploadResultInfo is the minimal view of one of the backup's
+// DataUploadResult configmaps, playing the same role as VolumeSnapshotInfo
+// for volumes backed up through Velero's DataUpload/Kopia path.
+type DataUploadResultInfo struct {
+	SourceName      string
+	SourceNamespace string
+	RestoredPVCName string
+}
+
+// listBackupVolumeSnapshots finds the PVCs CSI provisioned in namespace from
+// VolumeSnapshots, matching each back to the PVC it originally backed up. It
+// is a package level variable so tests can stub it with synthetic metadata.
+var listBackupVolumeSnapshots = func(namespace string) ([]VolumeSnapshotInfo, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("building in-cluster config: %w", err)
+	}
+
+	snapshotClient, err := snapshotclientset.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating snapshot client: %w", err)
+	}
+
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating kubernetes client: %w", err)
+	}
+
+	return (&backupVolumeSnapshotLister{snapshotClient: snapshotClient, client: client}).list(namespace)
+}
+
+// listDataUploadResults reads the DataUploadResult configmaps Velero's
+// node-agent leaves behind for backupName. It is a package level variable so
+// tests can stub it with synthetic metadata.
+var listDataUploadResults = func(backupName string) ([]DataUploadResultInfo, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("building in-cluster config: %w", err)
+	}
+
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating kubernetes client: %w", err)
+	}
+
+	return (&dataUploadResultLister{client: client}).list(backupName)
+}
+
+// VMIRestoreItemAction rewrites a VMI's volumes during restore so that
+// PVC-backed volumes point at the PVCs provisioned from the backup's
+// VolumeSnapshots or DataUploadResults, and DataVolume-backed volumes that
+// cannot be re-hydrated are converted to plain PVC volumes instead. The
+// launcher pod is never part of its additional items: KubeVirt creates it
+// once the VMI is admitted.
+type VMIRestoreItemAction struct {
+	log logrus.FieldLogger
+}
+
+// NewVMIRestoreItemAction creates a new VMIRestoreItemAction.
+func NewVMIRestoreItemAction(log logrus.FieldLogger) *VMIRestoreItemAction {
+	return &VMIRestoreItemAction{log: log}
+}
+
+// AppliesTo returns the resources that this action should be invoked for.
+func (p *VMIRestoreItemAction) AppliesTo() (velero.ResourceSelector, error) {
+	return velero.ResourceSelector{
+		IncludedResources: []string{"virtualmachineinstances.kubevirt.io"},
+	}, nil
+}
+
+// Execute rewrites vmi's volumes to reference the PVCs restored from the
+// backup's VolumeSnapshots/DataUploadResults.
+func (p *VMIRestoreItemAction) Execute(input *velero.RestoreItemActionExecuteInput) (*velero.RestoreItemActionExecuteOutput, error) {
+	p.log.Info("Executing VMIRestoreItemAction")
+
+	unstructuredVMI, ok := input.Item.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("item is not an unstructured.Unstructured: %T", input.Item)
+	}
+
+	var vmi kvcore.VirtualMachineInstance
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredVMI.UnstructuredContent(), &vmi); err != nil {
+		return nil, err
+	}
+
+	backupName := input.Restore.Spec.BackupName
+
+	snapshots, err := listBackupVolumeSnapshots(vmi.Namespace)
+	if err != nil {
+		p.log.Warnf("could not resolve restored VolumeSnapshots in namespace %q: %v", vmi.Namespace, err)
+	}
+
+	dataUploads, err := listDataUploadResults(backupName)
+	if err != nil {
+		p.log.Warnf("could not load DataUploadResult metadata for backup %q: %v", backupName, err)
+	}
+
+	originalUID := vmi.UID
+
+	for i, volume := range vmi.Spec.Volumes {
+		switch {
+		case volume.PersistentVolumeClaim != nil:
+			claimName := volume.PersistentVolumeClaim.ClaimName
+			restored, found := restoredPVCName(snapshots, dataUploads, claimName, vmi.Namespace)
+			if !found {
+				p.log.Warnf("no VolumeSnapshot or DataUploadResult found for PVC %s/%s backing VMI %s/%s, leaving volume as-is", vmi.Namespace, claimName, vmi.Namespace, vmi.Name)
+				continue
+			}
+			vmi.Spec.Volumes[i].PersistentVolumeClaim.ClaimName = restored
+		case volume.DataVolume != nil:
+			dvName := volume.DataVolume.Name
+			restored, found := restoredPVCName(snapshots, dataUploads, dvName, vmi.Namespace)
+			if !found {
+				p.log.Warnf("DataVolume %s/%s for VMI %s/%s could not be re-hydrated, leaving volume as-is", vmi.Namespace, dvName, vmi.Namespace, vmi.Name)
+				continue
+			}
+			vmi.Spec.Volumes[i] = pvcVolumeFromDataVolume(volume.Name, restored)
+		}
+	}
+
+	if vmi.Annotations == nil {
+		vmi.Annotations = map[string]string{}
+	}
+	vmi.Annotations[annOriginalVMIUID] = string(originalUID)
+
+	raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&vmi)
+	if err != nil {
+		return nil, err
+	}
+	unstructuredVMI.Object = raw
+
+	return velero.NewRestoreItemActionExecuteOutput(unstructuredVMI), nil
+}
+
+// restoredPVCName looks sourceName (the original PVC or DataVolume name) up
+// in snapshots, falling back to dataUploads, and returns the name of the PVC
+// that was provisioned from it during restore.
+func restoredPVCName(snapshots []VolumeSnapshotInfo, dataUploads []DataUploadResultInfo, sourceName, sourceNamespace string) (string, bool) {
+	for _, vs := range snapshots {
+		if vs.SourcePVCName == sourceName && vs.SourcePVCNamespace == sourceNamespace {
+			return vs.RestoredPVCName, true
+		}
+	}
+
+	for _, du := range dataUploads {
+		if du.SourceName == sourceName && du.SourceNamespace == sourceNamespace {
+			return du.RestoredPVCName, true
+		}
+	}
+
+	return "", false
+}
+
+// pvcVolumeFromDataVolume converts a DataVolume-backed volume into a plain
+// PVC volume pointing at restoredPVCName, keeping the original volume name.
+func pvcVolumeFromDataVolume(volumeName, restoredPVCName string) kvcore.Volume {
+	return kvcore.Volume{
+		Name: volumeName,
+		VolumeSource: kvcore.VolumeSource{
+			PersistentVolumeClaim: &kvcore.PersistentVolumeClaimVolumeSource{
+				PersistentVolumeClaimVolumeSource: corev1.PersistentVolumeClaimVolumeSource{ClaimName: restoredPVCName},
+			},
+		},
+	}
+}
+
+// backupVolumeSnapshotLister resolves the PVCs CSI provisioned in a
+// namespace from VolumeSnapshots back to the PVC each snapshot originally
+// backed up.
+type backupVolumeSnapshotLister struct {
+	snapshotClient snapshotclientset.Interface
+	client         kubernetes.Interface
+}
+
+func (l *backupVolumeSnapshotLister) list(namespace string) ([]VolumeSnapshotInfo, error) {
+	snapshots, err := l.snapshotClient.SnapshotV1().VolumeSnapshots(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing VolumeSnapshots in %s: %w", namespace, err)
+	}
+
+	pvcs, err := l.client.CoreV1().PersistentVolumeClaims(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing PersistentVolumeClaims in %s: %w", namespace, err)
+	}
+
+	var infos []VolumeSnapshotInfo
+	for _, vs := range snapshots.Items {
+		if vs.Spec.Source.PersistentVolumeClaimName == nil {
+			continue
+		}
+
+		restoredPVCName, ok := pvcRestoredFromVolumeSnapshot(pvcs.Items, vs.Name)
+		if !ok {
+			continue
+		}
+
+		infos = append(infos, VolumeSnapshotInfo{
+			SourcePVCName:      *vs.Spec.Source.PersistentVolumeClaimName,
+			SourcePVCNamespace: namespace,
+			RestoredPVCName:    restoredPVCName,
+		})
+	}
+
+	return infos, nil
+}
+
+// volumeSnapshotDataSourceKind is the dataSource kind of a PVC provisioned
+// from a VolumeSnapshot.
+const volumeSnapshotDataSourceKind = "VolumeSnapshot"
+
+// pvcRestoredFromVolumeSnapshot returns the name of the PVC in pvcs whose
+// dataSource is the VolumeSnapshot named snapshotName.
+func pvcRestoredFromVolumeSnapshot(pvcs []corev1.PersistentVolumeClaim, snapshotName string) (string, bool) {
+	for _, pvc := range pvcs {
+		ds := pvc.Spec.DataSource
+		if ds != nil && ds.Kind == volumeSnapshotDataSourceKind && ds.Name == snapshotName {
+			return pvc.Name, true
+		}
+	}
+
+	return "", false
+}
+
+// dataUploadResultLister reads the DataUploadResult configmaps Velero's
+// node-agent leaves behind in its own namespace once a DataUpload-backed
+// volume has been restored.
+type dataUploadResultLister struct {
+	client kubernetes.Interface
+}
+
+func (l *dataUploadResultLister) list(backupName string) ([]DataUploadResultInfo, error) {
+	cms, err := l.client.CoreV1().ConfigMaps(veleroNamespace()).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s,%s=%s", dataUploadResultBackupLabel, backupName, dataUploadResultUsageLabel, dataUploadResultUsageValue),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing DataUploadResult configmaps for backup %s: %w", backupName, err)
+	}
+
+	var infos []DataUploadResultInfo
+	for _, cm := range cms.Items {
+		sourceName := cm.Data[dataUploadResultSourceNameKey]
+		restoredPVCName := cm.Data[dataUploadResultRestoredPVCKey]
+		if sourceName == "" || restoredPVCName == "" {
+			continue
+		}
+
+		infos = append(infos, DataUploadResultInfo{
+			SourceName:      sourceName,
+			SourceNamespace: cm.Data[dataUploadResultSourceNamespaceKey],
+			RestoredPVCName: restoredPVCName,
+		})
+	}
+
+	return infos, nil
+}
+
+// veleroNamespace returns the namespace Velero's server and node-agent run
+// in, so DataUploadResult configmaps can be found there.
+func veleroNamespace() string {
+	if ns := os.Getenv(veleroNamespaceEnvVar); ns != "" {
+		return ns
+	}
+
+	return defaultVeleroNamespace
+}
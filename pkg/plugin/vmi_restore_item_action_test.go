@@ -0,0 +1,157 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kvcore "kubevirt.io/api/core/v1"
+)
+
+func restoreInputFor(t *testing.T, vmi *kvcore.VirtualMachineInstance, backupName string) *velero.RestoreItemActionExecuteInput {
+	t.Helper()
+
+	raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(vmi)
+	assert.NoError(t, err)
+
+	return &velero.RestoreItemActionExecuteInput{
+		Item:    &unstructured.Unstructured{Object: raw},
+		Restore: &velerov1.Restore{Spec: velerov1.RestoreSpec{BackupName: backupName}},
+	}
+}
+
+func TestVMIRestoreItemActionRewritesPVCVolumeFromSnapshot(t *testing.T) {
+	origListSnapshots := listBackupVolumeSnapshots
+	origListDataUploads := listDataUploadResults
+	defer func() {
+		listBackupVolumeSnapshots = origListSnapshots
+		listDataUploadResults = origListDataUploads
+	}()
+
+	listBackupVolumeSnapshots = func(namespace string) ([]VolumeSnapshotInfo, error) {
+		assert.Equal(t, "test-namespace", namespace)
+		return []VolumeSnapshotInfo{
+			{SourcePVCName: "disk-pvc", SourcePVCNamespace: "test-namespace", RestoredPVCName: "disk-pvc-restored"},
+		}, nil
+	}
+	listDataUploadResults = func(backupName string) ([]DataUploadResultInfo, error) {
+		return nil, nil
+	}
+
+	vmi := &kvcore.VirtualMachineInstance{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "test-vmi", UID: types.UID("original-uid")},
+		Spec: kvcore.VirtualMachineInstanceSpec{
+			Volumes: []kvcore.Volume{
+				{
+					Name: "disk",
+					VolumeSource: kvcore.VolumeSource{
+						PersistentVolumeClaim: &kvcore.PersistentVolumeClaimVolumeSource{
+							PersistentVolumeClaimVolumeSource: corev1.PersistentVolumeClaimVolumeSource{ClaimName: "disk-pvc"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	action := NewVMIRestoreItemAction(logrus.StandardLogger())
+	output, err := action.Execute(restoreInputFor(t, vmi, "test-backup"))
+	assert.NoError(t, err)
+
+	var restored kvcore.VirtualMachineInstance
+	assert.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(output.UpdatedItem.(*unstructured.Unstructured).UnstructuredContent(), &restored))
+
+	assert.Equal(t, "disk-pvc-restored", restored.Spec.Volumes[0].PersistentVolumeClaim.ClaimName)
+	assert.Equal(t, "original-uid", restored.Annotations[annOriginalVMIUID])
+}
+
+func TestVMIRestoreItemActionConvertsUnrehydratableDataVolumeToPVC(t *testing.T) {
+	origListSnapshots := listBackupVolumeSnapshots
+	origListDataUploads := listDataUploadResults
+	defer func() {
+		listBackupVolumeSnapshots = origListSnapshots
+		listDataUploadResults = origListDataUploads
+	}()
+
+	listBackupVolumeSnapshots = func(namespace string) ([]VolumeSnapshotInfo, error) {
+		return nil, nil
+	}
+	listDataUploadResults = func(backupName string) ([]DataUploadResultInfo, error) {
+		return []DataUploadResultInfo{
+			{SourceName: "my-dv", SourceNamespace: "test-namespace", RestoredPVCName: "my-dv-restored"},
+		}, nil
+	}
+
+	vmi := &kvcore.VirtualMachineInstance{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "test-vmi"},
+		Spec: kvcore.VirtualMachineInstanceSpec{
+			Volumes: []kvcore.Volume{
+				{
+					Name: "disk",
+					VolumeSource: kvcore.VolumeSource{
+						DataVolume: &kvcore.DataVolumeSource{Name: "my-dv"},
+					},
+				},
+			},
+		},
+	}
+
+	action := NewVMIRestoreItemAction(logrus.StandardLogger())
+	output, err := action.Execute(restoreInputFor(t, vmi, "test-backup"))
+	assert.NoError(t, err)
+
+	var restored kvcore.VirtualMachineInstance
+	assert.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(output.UpdatedItem.(*unstructured.Unstructured).UnstructuredContent(), &restored))
+
+	assert.Nil(t, restored.Spec.Volumes[0].DataVolume)
+	assert.NotNil(t, restored.Spec.Volumes[0].PersistentVolumeClaim)
+	assert.Equal(t, "my-dv-restored", restored.Spec.Volumes[0].PersistentVolumeClaim.ClaimName)
+}
+
+func TestVMIRestoreItemActionWarnsWithoutFailingWhenSnapshotMissing(t *testing.T) {
+	origListSnapshots := listBackupVolumeSnapshots
+	origListDataUploads := listDataUploadResults
+	defer func() {
+		listBackupVolumeSnapshots = origListSnapshots
+		listDataUploadResults = origListDataUploads
+	}()
+
+	listBackupVolumeSnapshots = func(namespace string) ([]VolumeSnapshotInfo, error) {
+		return nil, nil
+	}
+	listDataUploadResults = func(backupName string) ([]DataUploadResultInfo, error) {
+		return nil, nil
+	}
+
+	vmi := &kvcore.VirtualMachineInstance{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "test-vmi"},
+		Spec: kvcore.VirtualMachineInstanceSpec{
+			Volumes: []kvcore.Volume{
+				{
+					Name: "disk",
+					VolumeSource: kvcore.VolumeSource{
+						PersistentVolumeClaim: &kvcore.PersistentVolumeClaimVolumeSource{
+							PersistentVolumeClaimVolumeSource: corev1.PersistentVolumeClaimVolumeSource{ClaimName: "disk-pvc"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	action := NewVMIRestoreItemAction(logrus.StandardLogger())
+	output, err := action.Execute(restoreInputFor(t, vmi, "test-backup"))
+	assert.NoError(t, err)
+
+	var restored kvcore.VirtualMachineInstance
+	assert.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(output.UpdatedItem.(*unstructured.Unstructured).UnstructuredContent(), &restored))
+
+	assert.Equal(t, "disk-pvc", restored.Spec.Volumes[0].PersistentVolumeClaim.ClaimName)
+}
@@ -0,0 +1,193 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kvcore "kubevirt.io/api/core/v1"
+)
+
+const (
+	// annUploaderType records which uploader backend backed up a VMI's
+	// filesystem-mode volumes, so the restore side can select a matching
+	// restorer.
+	annUploaderType = "kubevirt.io/velero.uploader"
+
+	diskMountPathPrefix = "/var/run/kubevirt-private/vmi-disks"
+)
+
+var podVolumeBackupGVR = schema.GroupVersionResource{Group: "velero.io", Version: "v1", Resource: "podvolumebackups"}
+var podVolumeBackupGR = schema.GroupResource{Group: "velero.io", Resource: "podvolumebackups"}
+
+// selectFilesystemVolumes returns the subset of volumes that are
+// filesystem-mode PVC volumes, which is what addUploaderBackups/
+// uploaderBackupExtras back up through the uploader instead of CSI. It
+// returns nil without making any calls when no uploader type is configured.
+func (p *VMIBackupItemAction) selectFilesystemVolumes(namespace string, volumes []kvcore.Volume) ([]kvcore.Volume, error) {
+	if p.uploaderType == "" {
+		return nil, nil
+	}
+
+	fsVolumes := []kvcore.Volume{}
+	for _, volume := range volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+
+		isFS, err := p.isFilesystemVolume(namespace, volume.PersistentVolumeClaim.ClaimName)
+		if err != nil {
+			return nil, err
+		}
+		if isFS {
+			fsVolumes = append(fsVolumes, volume)
+		}
+	}
+
+	return fsVolumes, nil
+}
+
+// addUploaderBackups requests a file-level backup of every volume in
+// fsVolumes directly from inside the launcher pod, instead of deferring
+// entirely to CSI, by emitting a PodVolumeBackup-style CR per disk and
+// recording the chosen uploader type on the VMI. It returns the subset of
+// fsVolumes it actually claimed, which is empty (not fsVolumes) whenever it
+// bails out early: callers must fall back to backing up an unclaimed volume
+// some other way rather than assuming the uploader handled it. In
+// particular, if the uploader repository for vmi's namespace cannot be
+// provisioned (the Kopia/Restic backends do not implement this yet), it logs
+// a warning and leaves fsVolumes to CSI instead of failing the whole backup.
+func (p *VMIBackupItemAction) addUploaderBackups(unstructuredVMI *unstructured.Unstructured, vmi *kvcore.VirtualMachineInstance, fsVolumes []kvcore.Volume, extra []velero.ResourceIdentifier) ([]velero.ResourceIdentifier, map[string]bool, error) {
+	if len(fsVolumes) == 0 {
+		return extra, nil, nil
+	}
+
+	launcherPodName, err := p.findLauncherPodName(vmi)
+	if err != nil {
+		return nil, nil, err
+	}
+	if launcherPodName == "" {
+		return extra, nil, nil
+	}
+
+	if err := p.repoEnsurer.EnsureRepository(context.TODO(), vmi.Namespace, p.uploaderType); err != nil {
+		p.log.Warnf("uploader repository not available for %s/%s, leaving its filesystem-mode volumes to CSI: %v", vmi.Namespace, vmi.Name, err)
+		return extra, nil, nil
+	}
+
+	claimed := make(map[string]bool, len(fsVolumes))
+	for _, volume := range fsVolumes {
+		name := podVolumeBackupName(launcherPodName, volume.Name)
+
+		pvb := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "velero.io/v1",
+			"kind":       "PodVolumeBackup",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": vmi.Namespace,
+			},
+			"spec": map[string]interface{}{
+				"pod": map[string]interface{}{
+					"name":      launcherPodName,
+					"namespace": vmi.Namespace,
+				},
+				"volume":       volume.Name,
+				"mountPath":    diskMountPath(volume.Name),
+				"uploaderType": string(p.uploaderType),
+			},
+		}}
+
+		if _, err := p.dynamicClient.Resource(podVolumeBackupGVR).Namespace(vmi.Namespace).Create(context.TODO(), pvb, metav1.CreateOptions{}); err != nil {
+			return nil, nil, fmt.Errorf("creating PodVolumeBackup %s/%s: %w", vmi.Namespace, name, err)
+		}
+
+		extra = append(extra, velero.ResourceIdentifier{
+			GroupResource: podVolumeBackupGR,
+			Namespace:     vmi.Namespace,
+			Name:          name,
+		})
+		claimed[volume.Name] = true
+	}
+
+	setAnnotation(unstructuredVMI, annUploaderType, string(p.uploaderType))
+
+	return extra, claimed, nil
+}
+
+// uploaderBackupExtras reports the PodVolumeBackups addUploaderBackups would
+// create for fsVolumes and the volumes it would claim, without ensuring the
+// repository or creating anything. It is used by GetAdditionalItems in place
+// of addUploaderBackups, since that call must stay side-effect free; it
+// cannot predict an EnsureRepository failure, so it optimistically assumes
+// every volume in fsVolumes would be claimed.
+func (p *VMIBackupItemAction) uploaderBackupExtras(vmi *kvcore.VirtualMachineInstance, fsVolumes []kvcore.Volume, extra []velero.ResourceIdentifier) ([]velero.ResourceIdentifier, map[string]bool, error) {
+	if len(fsVolumes) == 0 {
+		return extra, nil, nil
+	}
+
+	launcherPodName, err := p.findLauncherPodName(vmi)
+	if err != nil {
+		return nil, nil, err
+	}
+	if launcherPodName == "" {
+		return extra, nil, nil
+	}
+
+	claimed := make(map[string]bool, len(fsVolumes))
+	for _, volume := range fsVolumes {
+		extra = append(extra, velero.ResourceIdentifier{
+			GroupResource: podVolumeBackupGR,
+			Namespace:     vmi.Namespace,
+			Name:          podVolumeBackupName(launcherPodName, volume.Name),
+		})
+		claimed[volume.Name] = true
+	}
+
+	return extra, claimed, nil
+}
+
+// podVolumeBackupName derives the deterministic name of the PodVolumeBackup
+// created for volumeName inside the pod named launcherPodName.
+func podVolumeBackupName(launcherPodName, volumeName string) string {
+	return fmt.Sprintf("%s-%s", launcherPodName, volumeName)
+}
+
+// findLauncherPodName returns the name of vmi's launcher pod, or "" if none
+// is found.
+func (p *VMIBackupItemAction) findLauncherPodName(vmi *kvcore.VirtualMachineInstance) (string, error) {
+	pods, err := p.client.CoreV1().Pods(vmi.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", launcherLabel, launcherLabelVal),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Annotations[launcherDomainAnn] == vmi.Name {
+			return pod.Name, nil
+		}
+	}
+
+	return "", nil
+}
+
+// isFilesystemVolume reports whether the PVC backing claimName is in
+// Filesystem mode (the default when VolumeMode is unset).
+func (p *VMIBackupItemAction) isFilesystemVolume(namespace, claimName string) (bool, error) {
+	pvc, err := p.client.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), claimName, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	return pvc.Spec.VolumeMode == nil || *pvc.Spec.VolumeMode == corev1.PersistentVolumeFilesystem, nil
+}
+
+// diskMountPath returns the path at which volumeName is mounted inside the
+// launcher pod.
+func diskMountPath(volumeName string) string {
+	return fmt.Sprintf("%s/%s", diskMountPathPrefix, volumeName)
+}
@@ -0,0 +1,155 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	kvcore "kubevirt.io/api/core/v1"
+
+	"kubevirt.io/kubevirt-velero-plugin/pkg/repository"
+	"kubevirt.io/kubevirt-velero-plugin/pkg/uploader"
+)
+
+func volumeModeBlock() *corev1.PersistentVolumeMode {
+	m := corev1.PersistentVolumeBlock
+	return &m
+}
+
+func TestAddUploaderBackups(t *testing.T) {
+	vmi := &kvcore.VirtualMachineInstance{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "test-vmi"},
+	}
+	volumes := []kvcore.Volume{
+		{
+			Name: "fs-disk",
+			VolumeSource: kvcore.VolumeSource{
+				PersistentVolumeClaim: &kvcore.PersistentVolumeClaimVolumeSource{
+					PersistentVolumeClaimVolumeSource: corev1.PersistentVolumeClaimVolumeSource{ClaimName: "fs-pvc"},
+				},
+			},
+		},
+		{
+			Name: "block-disk",
+			VolumeSource: kvcore.VolumeSource{
+				PersistentVolumeClaim: &kvcore.PersistentVolumeClaimVolumeSource{
+					PersistentVolumeClaimVolumeSource: corev1.PersistentVolumeClaimVolumeSource{ClaimName: "block-pvc"},
+				},
+			},
+		},
+	}
+
+	launcherPod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "test-namespace",
+			Name:      "test-vmi-launcher-pod",
+			Labels:    map[string]string{"kubevirt.io": "virt-launcher"},
+			Annotations: map[string]string{
+				"kubevirt.io/domain": "test-vmi",
+			},
+		},
+	}
+	fsPVC := corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "fs-pvc"},
+	}
+	blockPVC := corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "block-pvc"},
+		Spec:       corev1.PersistentVolumeClaimSpec{VolumeMode: volumeModeBlock()},
+	}
+
+	client := k8sfake.NewSimpleClientset(&launcherPod, &fsPVC, &blockPVC)
+
+	scheme := runtime.NewScheme()
+	gvrToListKind := map[schema.GroupVersionResource]string{
+		podVolumeBackupGVR: "PodVolumeBackupList",
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme, gvrToListKind)
+
+	action := &VMIBackupItemAction{
+		log:           logrus.StandardLogger(),
+		client:        client,
+		dynamicClient: dynamicClient,
+		uploaderType:  uploader.Kopia,
+		repoEnsurer: repository.New(func(ctx context.Context, namespace string, t uploader.Type) error {
+			return nil
+		}),
+	}
+
+	raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(vmi)
+	assert.NoError(t, err)
+	unstructuredVMI := &unstructured.Unstructured{Object: raw}
+
+	fsVolumes, err := action.selectFilesystemVolumes(vmi.Namespace, volumes)
+	assert.NoError(t, err)
+	assert.Len(t, fsVolumes, 1)
+
+	extra, claimed, err := action.addUploaderBackups(unstructuredVMI, vmi, fsVolumes, nil)
+	assert.NoError(t, err)
+	assert.Len(t, extra, 1)
+	assert.Equal(t, "test-vmi-launcher-pod-fs-disk", extra[0].Name)
+	assert.Equal(t, podVolumeBackupGR, extra[0].GroupResource)
+	assert.Equal(t, "kopia", unstructuredVMI.GetAnnotations()[annUploaderType])
+	assert.Equal(t, map[string]bool{"fs-disk": true}, claimed)
+
+	created, err := dynamicClient.Resource(podVolumeBackupGVR).Namespace("test-namespace").Get(context.TODO(), "test-vmi-launcher-pod-fs-disk", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.NotNil(t, created)
+}
+
+func TestAddUploaderBackupsSkipsWhenRepositoryUnavailable(t *testing.T) {
+	logrus.SetLevel(logrus.ErrorLevel)
+
+	vmi := &kvcore.VirtualMachineInstance{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "test-vmi"},
+	}
+	fsVolumes := []kvcore.Volume{
+		{
+			Name: "fs-disk",
+			VolumeSource: kvcore.VolumeSource{
+				PersistentVolumeClaim: &kvcore.PersistentVolumeClaimVolumeSource{
+					PersistentVolumeClaimVolumeSource: corev1.PersistentVolumeClaimVolumeSource{ClaimName: "fs-pvc"},
+				},
+			},
+		},
+	}
+
+	launcherPod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "test-namespace",
+			Name:      "test-vmi-launcher-pod",
+			Labels:    map[string]string{"kubevirt.io": "virt-launcher"},
+			Annotations: map[string]string{
+				"kubevirt.io/domain": "test-vmi",
+			},
+		},
+	}
+
+	client := k8sfake.NewSimpleClientset(&launcherPod)
+
+	action := &VMIBackupItemAction{
+		log:          logrus.StandardLogger(),
+		client:       client,
+		uploaderType: uploader.Kopia,
+		repoEnsurer: repository.New(func(ctx context.Context, namespace string, t uploader.Type) error {
+			return assert.AnError
+		}),
+	}
+
+	raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(vmi)
+	assert.NoError(t, err)
+	unstructuredVMI := &unstructured.Unstructured{Object: raw}
+
+	extra, claimed, err := action.addUploaderBackups(unstructuredVMI, vmi, fsVolumes, nil)
+	assert.NoError(t, err, "a repository provisioning failure must not fail the whole backup")
+	assert.Empty(t, extra)
+	assert.Empty(t, claimed)
+	assert.Empty(t, unstructuredVMI.GetAnnotations()[annUploaderType])
+}
@@ -0,0 +1,87 @@
+// Package repository ensures a per-namespace backup repository exists for
+// the pluggable uploader backends in pkg/uploader before they are asked to
+// read or write volume data.
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"kubevirt.io/kubevirt-velero-plugin/pkg/uploader"
+)
+
+// repoMarkerConfigMapName is the marker left behind once a namespace's
+// repository has been ensured, so repeated backups don't redo the work.
+const repoMarkerConfigMapName = "kubevirt-velero-uploader-repo"
+
+// RepositoryEnsurer makes sure a per-namespace backup repository exists,
+// creating it lazily the first time the namespace is seen.
+type RepositoryEnsurer struct {
+	mu      sync.Mutex
+	ensured map[string]bool
+	ensure  func(ctx context.Context, namespace string, uploaderType uploader.Type) error
+}
+
+// New creates a RepositoryEnsurer that calls ensure to create the repository
+// the first time a namespace is seen. It is the low-level constructor used
+// by tests to supply a fake; production code should use NewForClient.
+func New(ensure func(ctx context.Context, namespace string, uploaderType uploader.Type) error) *RepositoryEnsurer {
+	return &RepositoryEnsurer{
+		ensured: map[string]bool{},
+		ensure:  ensure,
+	}
+}
+
+// NewForClient creates a RepositoryEnsurer backed by client: it provisions
+// the namespace's repository through the chosen uploader backend, then
+// records that it did so with a ConfigMap so repeated backups don't redo the
+// work.
+func NewForClient(client kubernetes.Interface) *RepositoryEnsurer {
+	return New(func(ctx context.Context, namespace string, uploaderType uploader.Type) error {
+		repo, err := uploader.NewRepository(uploaderType)
+		if err != nil {
+			return err
+		}
+
+		if err := repo.EnsureRepository(ctx, uploader.RepositoryLocation{Namespace: namespace}); err != nil {
+			return fmt.Errorf("provisioning %s repository for namespace %s: %w", uploaderType, namespace, err)
+		}
+
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      repoMarkerConfigMapName,
+				Namespace: namespace,
+				Labels:    map[string]string{"kubevirt.io/velero.uploader": string(uploaderType)},
+			},
+		}
+		_, err = client.CoreV1().ConfigMaps(namespace).Create(ctx, cm, metav1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	})
+}
+
+// EnsureRepository creates the backup repository for namespace if this
+// instance has not already ensured one.
+func (e *RepositoryEnsurer) EnsureRepository(ctx context.Context, namespace string, uploaderType uploader.Type) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.ensured[namespace] {
+		return nil
+	}
+
+	if err := e.ensure(ctx, namespace, uploaderType); err != nil {
+		return err
+	}
+
+	e.ensured[namespace] = true
+	return nil
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	"kubevirt.io/kubevirt-velero-plugin/pkg/uploader"
+)
+
+func TestEnsureRepositoryOnlyCallsEnsureOncePerNamespace(t *testing.T) {
+	calls := 0
+	ensurer := New(func(ctx context.Context, namespace string, uploaderType uploader.Type) error {
+		calls++
+		return nil
+	})
+
+	assert.NoError(t, ensurer.EnsureRepository(context.Background(), "ns-a", uploader.Kopia))
+	assert.NoError(t, ensurer.EnsureRepository(context.Background(), "ns-a", uploader.Kopia))
+	assert.Equal(t, 1, calls)
+
+	assert.NoError(t, ensurer.EnsureRepository(context.Background(), "ns-b", uploader.Kopia))
+	assert.Equal(t, 2, calls)
+}
+
+func TestEnsureRepositoryPropagatesError(t *testing.T) {
+	ensurer := New(func(ctx context.Context, namespace string, uploaderType uploader.Type) error {
+		return assert.AnError
+	})
+
+	assert.Error(t, ensurer.EnsureRepository(context.Background(), "ns-a", uploader.Restic))
+}
+
+func TestNewForClientProvisionsRepositoryBeforeMarkingItEnsured(t *testing.T) {
+	client := k8sfake.NewSimpleClientset()
+	ensurer := NewForClient(client)
+
+	// Neither uploader backend actually provisions a repository yet, so
+	// EnsureRepository must surface that failure rather than silently
+	// marking the namespace as done.
+	err := ensurer.EnsureRepository(context.Background(), "ns-a", uploader.Kopia)
+	assert.Error(t, err)
+
+	_, err = client.CoreV1().ConfigMaps("ns-a").Get(context.Background(), repoMarkerConfigMapName, metav1.GetOptions{})
+	assert.Error(t, err, "marker ConfigMap should not be created when repository provisioning fails")
+}
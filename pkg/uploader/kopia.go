@@ -0,0 +1,28 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+)
+
+// KopiaUploader is the Kopia uploader backend. None of its methods moves any
+// data: each one returns a "not implemented" error.
+type KopiaUploader struct{}
+
+// EnsureRepository returns a "not implemented" error; it does not create a
+// Kopia repository for location's namespace.
+func (u *KopiaUploader) EnsureRepository(ctx context.Context, location RepositoryLocation) error {
+	return fmt.Errorf("kopia repository provisioning for namespace %s not implemented", location.Namespace)
+}
+
+// BackupVolume returns a "not implemented" error; it does not back up the
+// volume mounted at req.MountPath inside req.PodName.
+func (u *KopiaUploader) BackupVolume(ctx context.Context, req BackupRequest) (BackupResult, error) {
+	return BackupResult{}, fmt.Errorf("kopia backup of %s:%s not implemented", req.PodName, req.MountPath)
+}
+
+// RestoreVolume returns a "not implemented" error; it does not restore
+// req.SnapshotID to the volume mounted at req.MountPath inside req.PodName.
+func (u *KopiaUploader) RestoreVolume(ctx context.Context, req RestoreRequest) (RestoreResult, error) {
+	return RestoreResult{}, fmt.Errorf("kopia restore of %s to %s:%s not implemented", req.SnapshotID, req.PodName, req.MountPath)
+}
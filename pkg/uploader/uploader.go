@@ -0,0 +1,116 @@
+// Package uploader mirrors Velero's uploader abstraction: a pluggable
+// Backupper/Restorer pair selectable by backend (Kopia or Restic) that moves
+// a single volume's file data in and out of a backup repository.
+package uploader
+
+import "context"
+
+// Type identifies which uploader backend to use.
+type Type string
+
+const (
+	// Kopia selects the Kopia uploader backend.
+	Kopia Type = "kopia"
+	// Restic selects the Restic uploader backend.
+	Restic Type = "restic"
+)
+
+// BackupRequest identifies the data to back up: the pod hosting the mounted
+// volume, and the path the volume is mounted at inside that pod.
+type BackupRequest struct {
+	Namespace string
+	PodName   string
+	MountPath string
+	Tags      map[string]string
+}
+
+// BackupResult describes the outcome of backing up a single volume's data.
+type BackupResult struct {
+	SnapshotID string
+}
+
+// RestoreRequest identifies the data to restore: the snapshot to pull and
+// where to place it.
+type RestoreRequest struct {
+	Namespace  string
+	PodName    string
+	MountPath  string
+	SnapshotID string
+}
+
+// RestoreResult describes the outcome of restoring a single volume's data.
+type RestoreResult struct{}
+
+// RepositoryLocation identifies the backend repository for one namespace.
+type RepositoryLocation struct {
+	Namespace string
+}
+
+// Repository provisions the backend repository a Backupper/Restorer reads
+// and writes through.
+type Repository interface {
+	// EnsureRepository creates the repository at location, including its
+	// encryption key and backend storage, if it does not already exist.
+	EnsureRepository(ctx context.Context, location RepositoryLocation) error
+}
+
+// Backupper uploads a volume's file data to a backup repository.
+type Backupper interface {
+	BackupVolume(ctx context.Context, req BackupRequest) (BackupResult, error)
+}
+
+// Restorer downloads a volume's file data from a backup repository.
+type Restorer interface {
+	RestoreVolume(ctx context.Context, req RestoreRequest) (RestoreResult, error)
+}
+
+// NewRepository returns the Repository implementation for the given uploader
+// type.
+func NewRepository(t Type) (Repository, error) {
+	switch t {
+	case Kopia:
+		return &KopiaUploader{}, nil
+	case Restic:
+		return &ResticUploader{}, nil
+	default:
+		return nil, unknownTypeError(t)
+	}
+}
+
+// NewBackupper returns the Backupper implementation for the given uploader
+// type.
+func NewBackupper(t Type) (Backupper, error) {
+	switch t {
+	case Kopia:
+		return &KopiaUploader{}, nil
+	case Restic:
+		return &ResticUploader{}, nil
+	default:
+		return nil, unknownTypeError(t)
+	}
+}
+
+// NewRestorer returns the Restorer implementation for the given uploader
+// type.
+func NewRestorer(t Type) (Restorer, error) {
+	switch t {
+	case Kopia:
+		return &KopiaUploader{}, nil
+	case Restic:
+		return &ResticUploader{}, nil
+	default:
+		return nil, unknownTypeError(t)
+	}
+}
+
+func unknownTypeError(t Type) error {
+	return &unsupportedTypeError{t}
+}
+
+type unsupportedTypeError struct {
+	t Type
+}
+
+func (e *unsupportedTypeError) Error() string {
+	return "unsupported uploader type: " + string(e.t)
+}
@@ -0,0 +1,29 @@
+package uploader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBackupper(t *testing.T) {
+	kopia, err := NewBackupper(Kopia)
+	assert.NoError(t, err)
+	assert.IsType(t, &KopiaUploader{}, kopia)
+
+	restic, err := NewBackupper(Restic)
+	assert.NoError(t, err)
+	assert.IsType(t, &ResticUploader{}, restic)
+
+	_, err = NewBackupper(Type("unknown"))
+	assert.Error(t, err)
+}
+
+func TestNewRestorer(t *testing.T) {
+	kopia, err := NewRestorer(Kopia)
+	assert.NoError(t, err)
+	assert.IsType(t, &KopiaUploader{}, kopia)
+
+	_, err = NewRestorer(Type("unknown"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,39 @@
+// Package util contains small helpers shared by the plugin's item actions.
+package util
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// ExcludeFromBackupLabel marks a resource as excluded from a Velero backup.
+const ExcludeFromBackupLabel = "velero.io/exclude-from-backup"
+
+// IsPVCExcludedByLabel reports whether the PersistentVolumeClaim identified by
+// namespace/name carries the exclude-from-backup label. It is a package level
+// variable so tests can stub it out without needing a live cluster.
+var IsPVCExcludedByLabel = func(namespace, name string) (bool, error) {
+	client, err := getClient()
+	if err != nil {
+		return false, err
+	}
+
+	pvc, err := client.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	return pvc.Labels[ExcludeFromBackupLabel] == "true", nil
+}
+
+func getClient() (kubernetes.Interface, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(cfg)
+}